@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/konamata/tsping/ipgeo"
+	"github.com/schollz/progressbar/v3"
+)
+
+// collectOptions bounds how aggressively collect pings a tailnet.
+// withDefaults() fills in the zero-value fields so callers only need to set
+// what they care about.
+type collectOptions struct {
+	// Concurrency caps how many peers are pinged at once.
+	Concurrency int
+	// PeerTimeout bounds how long pingIP spends on a single peer (all
+	// pingCount probes combined) before giving up on it.
+	PeerTimeout time.Duration
+}
+
+func (o collectOptions) withDefaults() collectOptions {
+	if o.Concurrency <= 0 {
+		o.Concurrency = runtime.NumCPU() * 4
+	}
+	if o.PeerTimeout <= 0 {
+		o.PeerTimeout = 10 * time.Second
+	}
+	return o
+}
+
+// collect runs one full round: check tailscaled is up, list peers, ping
+// them all, resolve external IPs to geo/ISP info, and group+sort the
+// results the way the table renderer expects. showProgress controls
+// whether a progress bar is drawn on stderr (skipped in --serve mode,
+// where it would just spam logs). ctx bounds the whole run (e.g. a
+// --deadline); it's fine to pass context.Background() for no deadline.
+func collect(ctx context.Context, geo ipgeo.Provider, opts collectOptions, showProgress bool) ([]PingResult, error) {
+	opts = opts.withDefaults()
+
+	if err := checkTailscale(); err != nil {
+		return nil, err
+	}
+
+	resultsList, err := getTailscaleStatus()
+	if err != nil {
+		return nil, fmt.Errorf("getting Tailscale status: %w", err)
+	}
+	if len(resultsList) == 0 {
+		return nil, nil
+	}
+
+	var wg sync.WaitGroup
+	var completed int32
+	sem := make(chan struct{}, opts.Concurrency)
+
+	var bar *progressbar.ProgressBar
+	if showProgress {
+		bar = progressbar.NewOptions(len(resultsList),
+			progressbar.OptionEnableColorCodes(true),
+			progressbar.OptionSetWidth(15),
+			progressbar.OptionSetDescription("[cyan]Getting ISP info...[reset]"),
+			progressbar.OptionSetTheme(progressbar.Theme{
+				Saucer:        "[green]=[reset]",
+				SaucerHead:    "[green]>[reset]",
+				SaucerPadding: " ",
+				BarStart:      "[",
+				BarEnd:        "]",
+			}),
+			progressbar.OptionSetWriter(os.Stderr),
+			progressbar.OptionOnCompletion(func() {
+				fmt.Fprintf(os.Stderr, "\n")
+			}),
+		)
+	}
+
+	for i := range resultsList {
+		wg.Add(1)
+		go func(result *PingResult) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			peerCtx, cancel := context.WithTimeout(ctx, opts.PeerTimeout)
+			defer cancel()
+
+			pingIP(peerCtx, result, geo, &wg, &completed)
+			if bar != nil {
+				bar.Add(1)
+			}
+		}(&resultsList[i])
+	}
+
+	wg.Wait()
+
+	// Use maps to track group numbers and counts
+	groupMap := make(map[string]int)
+	groupCounter := 1
+	groupCounts := make(map[string]int)
+
+	// First pass: Count devices per external IP
+	for _, result := range resultsList {
+		if result.externalIP != "" && isPublicIP(result.externalIP) {
+			groupCounts[result.externalIP]++
+		}
+	}
+
+	// Second pass: Assign groups with counts
+	for i := range resultsList {
+		externalIP := resultsList[i].externalIP
+		if externalIP == "" || !isPublicIP(externalIP) {
+			continue
+		}
+		if _, exists := groupMap[externalIP]; !exists {
+			groupMap[externalIP] = groupCounter
+			groupCounter++
+		}
+		count := groupCounts[externalIP]
+		resultsList[i].group = numberToLetterWithCount(groupMap[externalIP], count)
+	}
+
+	// Sort the resultsList
+	sort.Slice(resultsList, func(i, j int) bool {
+		if resultsList[i].group != resultsList[j].group {
+			return resultsList[i].group < resultsList[j].group
+		}
+		iAvg := calculateAverage(resultsList[i].pings)
+		jAvg := calculateAverage(resultsList[j].pings)
+		return iAvg < jAvg
+	})
+
+	return resultsList, nil
+}
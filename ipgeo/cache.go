@@ -0,0 +1,132 @@
+package ipgeo
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// lruCacheSize bounds the in-memory cache so a run against a huge tailnet
+// doesn't keep every GeoInfo resident forever.
+const lruCacheSize = 512
+
+// cachedProvider wraps another Provider with an in-memory LRU plus an
+// on-disk JSON cache keyed by IP, so repeated tsping runs (and repeated
+// peers sharing an exit node) don't re-query the backend.
+type cachedProvider struct {
+	next  Provider
+	path  string
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	dirty bool
+}
+
+type cacheEntry struct {
+	IP   string
+	Info GeoInfo
+}
+
+func newCachedProvider(next Provider, cacheDir string) (*cachedProvider, error) {
+	if cacheDir == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "tsping")
+		}
+	}
+
+	c := &cachedProvider{
+		next:  next,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+
+	if cacheDir != "" {
+		if err := os.MkdirAll(cacheDir, 0o755); err == nil {
+			c.path = filepath.Join(cacheDir, "ipgeo-cache.json")
+			c.load()
+		}
+	}
+
+	return c, nil
+}
+
+func (c *cachedProvider) Lookup(ip string) (GeoInfo, error) {
+	c.mu.Lock()
+	if el, ok := c.items[ip]; ok {
+		c.ll.MoveToFront(el)
+		info := el.Value.(*cacheEntry).Info
+		c.mu.Unlock()
+		return info, nil
+	}
+	c.mu.Unlock()
+
+	info, err := c.next.Lookup(ip)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	c.put(ip, info)
+	return info, nil
+}
+
+func (c *cachedProvider) put(ip string, info GeoInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[ip]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*cacheEntry).Info = info
+	} else {
+		el := c.ll.PushFront(&cacheEntry{IP: ip, Info: info})
+		c.items[ip] = el
+		for c.ll.Len() > lruCacheSize {
+			oldest := c.ll.Back()
+			if oldest == nil {
+				break
+			}
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*cacheEntry).IP)
+		}
+	}
+	c.dirty = true
+	c.save()
+}
+
+// load populates the in-memory LRU from the on-disk JSON cache, if any.
+func (c *cachedProvider) load() {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+
+	var entries []cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		el := c.ll.PushFront(&cacheEntry{IP: e.IP, Info: e.Info})
+		c.items[e.IP] = el
+	}
+}
+
+// save persists the current LRU contents to disk. Called with c.mu held.
+func (c *cachedProvider) save() {
+	if c.path == "" {
+		return
+	}
+
+	entries := make([]cacheEntry, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		entries = append(entries, *el.Value.(*cacheEntry))
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(c.path, data, 0o644)
+}
@@ -0,0 +1,56 @@
+package ipgeo
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCachedProviderCachesLookups(t *testing.T) {
+	backend := &countingProvider{}
+	c, err := newCachedProvider(backend, t.TempDir())
+	if err != nil {
+		t.Fatalf("newCachedProvider: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Lookup("1.2.3.4"); err != nil {
+			t.Fatalf("Lookup: %v", err)
+		}
+	}
+
+	if backend.calls != 1 {
+		t.Errorf("backend.calls = %d, want 1 (repeated lookups of the same IP should hit the cache)", backend.calls)
+	}
+}
+
+func TestCachedProviderEvictsLRU(t *testing.T) {
+	backend := &countingProvider{}
+	c, err := newCachedProvider(backend, t.TempDir())
+	if err != nil {
+		t.Fatalf("newCachedProvider: %v", err)
+	}
+
+	for i := 0; i < lruCacheSize+10; i++ {
+		ip := ipFromIndex(i)
+		if _, err := c.Lookup(ip); err != nil {
+			t.Fatalf("Lookup(%s): %v", ip, err)
+		}
+	}
+
+	if got := c.ll.Len(); got != lruCacheSize {
+		t.Errorf("cache size = %d, want capped at %d", got, lruCacheSize)
+	}
+
+	// The oldest entries should have been evicted.
+	if _, ok := c.items[ipFromIndex(0)]; ok {
+		t.Error("oldest entry still present, want it evicted once the cache exceeds lruCacheSize")
+	}
+	// The most recent entry should still be present.
+	if _, ok := c.items[ipFromIndex(lruCacheSize+9)]; !ok {
+		t.Error("most recent entry missing from cache")
+	}
+}
+
+func ipFromIndex(i int) string {
+	return "10." + strconv.Itoa(i/65536%256) + "." + strconv.Itoa(i/256%256) + "." + strconv.Itoa(i%256)
+}
@@ -0,0 +1,78 @@
+// Package ipgeo resolves IP addresses to geolocation/ISP info through a
+// set of pluggable backends (hosted HTTP APIs, or a fully offline
+// mmap-backed database), with a small shared cache in front of all of
+// them so repeated lookups across a run don't hit the network twice.
+package ipgeo
+
+import (
+	"fmt"
+)
+
+// GeoInfo is the normalized result returned by every Provider, regardless
+// of backend.
+type GeoInfo struct {
+	IP      string
+	ISP     string
+	ASN     string
+	Country string
+	Region  string
+	City    string
+	Lat     float64
+	Lon     float64
+}
+
+// Provider resolves a single IP to GeoInfo. Implementations may hit the
+// network, a local file, or both.
+type Provider interface {
+	// Lookup resolves ip to geolocation/ISP info. Implementations should
+	// return an error rather than a zero-value GeoInfo on failure so
+	// callers can distinguish "no data" from "lookup failed".
+	Lookup(ip string) (GeoInfo, error)
+}
+
+// Options configures provider construction. Fields are backend-specific;
+// unused ones are ignored by a given provider.
+type Options struct {
+	// Token authenticates with keyed providers such as ipinfo.io.
+	Token string
+	// DBPath is the path to an offline database file (e.g. an
+	// ip2region-style .xdb) used by local backends.
+	DBPath string
+	// CacheDir is where the on-disk JSON cache is stored. Defaults to
+	// the OS user cache dir if empty.
+	CacheDir string
+}
+
+// New builds the Provider named by backend ("ipapi", "ipinfo", "ipsb",
+// "ip2region", "ipinfoLocal"), wrapped in the shared cache. Networked
+// backends are additionally rate-limited; the offline ip2region/
+// ipinfoLocal backend is not, since it never makes an HTTP call and the
+// whole point of offering it is to resolve peers fast from restricted
+// networks.
+func New(backend string, opts Options) (Provider, error) {
+	var p Provider
+	var err error
+	networked := true
+
+	switch backend {
+	case "", "ipapi":
+		p = newIPAPIProvider()
+	case "ipinfo":
+		p = newIPInfoProvider(opts.Token)
+	case "ipsb":
+		p = newIPSBProvider()
+	case "ip2region", "ipinfoLocal":
+		p, err = newLocalProvider(opts.DBPath)
+		networked = false
+	default:
+		return nil, fmt.Errorf("ipgeo: unknown provider %q", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if networked {
+		p = newRateLimitedProvider(p)
+	}
+	return newCachedProvider(p, opts.CacheDir)
+}
@@ -0,0 +1,114 @@
+package ipgeo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/exp/mmap"
+)
+
+// localProvider resolves IPs fully offline against an ip2region-style
+// .xdb file, mmap'd once at startup. This is what lets tsping keep
+// working from networks where ip-api.com (and friends) are blocked or
+// rate-limited.
+//
+// .xdb layout (ip2region v2 format): a 256-byte header (skipped), a
+// VectorIndex of 256x256 entries indexed by the IP's first two bytes,
+// each holding the first and last pointer into the segment index, a
+// segment index of (start IP, end IP, data len, data ptr) rows, and a
+// Data section of "country|region|province|city|isp" strings.
+type localProvider struct {
+	r *mmap.ReaderAt
+}
+
+const (
+	xdbHeaderSize       = 256
+	xdbVectorIndexCols  = 256
+	xdbVectorIndexSize  = 8 // uint32 first segment ptr + uint32 last segment ptr, per column
+	xdbSegmentIndexSize = 14
+)
+
+func newLocalProvider(dbPath string) (*localProvider, error) {
+	if dbPath == "" {
+		return nil, fmt.Errorf("ipgeo: ip2region backend requires --ipgeo-db <path to .xdb>")
+	}
+
+	r, err := mmap.Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("ipgeo: opening xdb: %w", err)
+	}
+	return &localProvider{r: r}, nil
+}
+
+func (p *localProvider) Lookup(ip string) (GeoInfo, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return GeoInfo{}, fmt.Errorf("ipgeo: invalid ip %q", ip)
+	}
+	v4 := parsed.To4()
+	if v4 == nil {
+		return GeoInfo{}, fmt.Errorf("ipgeo: xdb backend only supports IPv4")
+	}
+	target := binary.BigEndian.Uint32(v4)
+
+	region, err := p.search(target)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	// ip2region regions are "country|region|province|city|isp"
+	parts := strings.Split(region, "|")
+	for len(parts) < 5 {
+		parts = append(parts, "")
+	}
+
+	return GeoInfo{
+		IP:      ip,
+		Country: parts[0],
+		Region:  parts[2],
+		City:    parts[3],
+		ISP:     parts[4],
+	}, nil
+}
+
+// search walks the xdb vector index to find the segment containing ip,
+// then reads and decodes that segment's region string.
+func (p *localProvider) search(ip uint32) (string, error) {
+	il0 := (ip >> 24) & 0xFF
+	il1 := (ip >> 16) & 0xFF
+
+	idxPos := xdbHeaderSize + (il0*xdbVectorIndexCols+il1)*xdbVectorIndexSize
+	buf := make([]byte, xdbVectorIndexSize)
+	if _, err := p.r.ReadAt(buf, int64(idxPos)); err != nil {
+		return "", fmt.Errorf("ipgeo: reading vector index: %w", err)
+	}
+
+	sPtr := binary.LittleEndian.Uint32(buf[0:4])
+	ePtr := binary.LittleEndian.Uint32(buf[4:8])
+
+	segBuf := make([]byte, xdbSegmentIndexSize)
+	for ptr := sPtr; ptr <= ePtr; ptr += xdbSegmentIndexSize {
+		if _, err := p.r.ReadAt(segBuf, int64(ptr)); err != nil {
+			return "", fmt.Errorf("ipgeo: reading segment index: %w", err)
+		}
+
+		startIP := binary.LittleEndian.Uint32(segBuf[0:4])
+		endIP := binary.LittleEndian.Uint32(segBuf[4:8])
+		if ip < startIP || ip > endIP {
+			continue
+		}
+
+		dataLen := binary.LittleEndian.Uint16(segBuf[8:10])
+		dataPtr := binary.LittleEndian.Uint32(segBuf[10:14])
+
+		data := make([]byte, dataLen)
+		if _, err := p.r.ReadAt(data, int64(dataPtr)); err != nil {
+			return "", fmt.Errorf("ipgeo: reading region data: %w", err)
+		}
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("ipgeo: no xdb segment found for ip")
+}
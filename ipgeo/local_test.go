@@ -0,0 +1,76 @@
+package ipgeo
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestXDB assembles a minimal ip2region-style .xdb file containing a
+// single segment covering ip, so the vector-index/segment-index walk in
+// search() has exactly one row to find.
+func buildTestXDB(t *testing.T, ip uint32, region string) string {
+	t.Helper()
+
+	vectorIndexLen := xdbVectorIndexCols * xdbVectorIndexCols * xdbVectorIndexSize
+	segPos := xdbHeaderSize + vectorIndexLen
+	dataPos := segPos + xdbSegmentIndexSize
+
+	buf := make([]byte, dataPos+len(region))
+
+	il0 := (ip >> 24) & 0xFF
+	il1 := (ip >> 16) & 0xFF
+	idxPos := xdbHeaderSize + int(il0*xdbVectorIndexCols+il1)*xdbVectorIndexSize
+	binary.LittleEndian.PutUint32(buf[idxPos:], uint32(segPos))
+	binary.LittleEndian.PutUint32(buf[idxPos+4:], uint32(segPos))
+
+	binary.LittleEndian.PutUint32(buf[segPos:], ip)
+	binary.LittleEndian.PutUint32(buf[segPos+4:], ip)
+	binary.LittleEndian.PutUint16(buf[segPos+8:], uint16(len(region)))
+	binary.LittleEndian.PutUint32(buf[segPos+10:], uint32(dataPos))
+
+	copy(buf[dataPos:], region)
+
+	path := filepath.Join(t.TempDir(), "test.xdb")
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatalf("writing test xdb: %v", err)
+	}
+	return path
+}
+
+func TestLocalProviderLookup(t *testing.T) {
+	const region = "China|0|Beijing|Beijing|ChinaNet"
+	ip := uint32(1)<<24 | uint32(2)<<16 | uint32(3)<<8 | uint32(4) // 1.2.3.4
+
+	path := buildTestXDB(t, ip, region)
+
+	p, err := newLocalProvider(path)
+	if err != nil {
+		t.Fatalf("newLocalProvider: %v", err)
+	}
+
+	got, err := p.Lookup("1.2.3.4")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+
+	want := GeoInfo{IP: "1.2.3.4", Country: "China", Region: "Beijing", City: "Beijing", ISP: "ChinaNet"}
+	if got != want {
+		t.Errorf("Lookup(1.2.3.4) = %+v, want %+v", got, want)
+	}
+}
+
+func TestLocalProviderLookupNoMatch(t *testing.T) {
+	ip := uint32(1)<<24 | uint32(2)<<16 | uint32(3)<<8 | uint32(4)
+	path := buildTestXDB(t, ip, "China|0|Beijing|Beijing|ChinaNet")
+
+	p, err := newLocalProvider(path)
+	if err != nil {
+		t.Fatalf("newLocalProvider: %v", err)
+	}
+
+	if _, err := p.Lookup("8.8.8.8"); err == nil {
+		t.Error("Lookup(8.8.8.8) = nil error, want a not-found error")
+	}
+}
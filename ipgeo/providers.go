@@ -0,0 +1,211 @@
+package ipgeo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ipapiProvider queries ip-api.com, the backend tsping originally used
+// inline. Free tier, no token required, 45 req/min rate limit.
+type ipapiProvider struct {
+	client *http.Client
+}
+
+func newIPAPIProvider() *ipapiProvider {
+	return &ipapiProvider{client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (p *ipapiProvider) Lookup(ip string) (GeoInfo, error) {
+	if ip == "" {
+		return GeoInfo{}, fmt.Errorf("ipgeo: empty ip")
+	}
+
+	resp, err := p.client.Get("http://ip-api.com/json/" + ip)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	var raw struct {
+		Status  string  `json:"status"`
+		ISP     string  `json:"isp"`
+		AS      string  `json:"as"` // "AS15169 Google LLC"
+		Country string  `json:"country"`
+		Region  string  `json:"regionName"`
+		City    string  `json:"city"`
+		Lat     float64 `json:"lat"`
+		Lon     float64 `json:"lon"`
+		Query   string  `json:"query"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return GeoInfo{}, err
+	}
+	if raw.Status != "success" {
+		return GeoInfo{}, fmt.Errorf("ip-api.com: lookup failed for %s", ip)
+	}
+
+	asn, _ := splitOrg(raw.AS)
+
+	return GeoInfo{
+		IP:      ip,
+		ISP:     raw.ISP,
+		ASN:     asn,
+		Country: raw.Country,
+		Region:  raw.Region,
+		City:    raw.City,
+		Lat:     raw.Lat,
+		Lon:     raw.Lon,
+	}, nil
+}
+
+// ipinfoProvider queries ipinfo.io, which requires a token for anything
+// beyond its small free quota.
+type ipinfoProvider struct {
+	client *http.Client
+	token  string
+}
+
+func newIPInfoProvider(token string) *ipinfoProvider {
+	return &ipinfoProvider{client: &http.Client{Timeout: 5 * time.Second}, token: token}
+}
+
+func (p *ipinfoProvider) Lookup(ip string) (GeoInfo, error) {
+	if ip == "" {
+		return GeoInfo{}, fmt.Errorf("ipgeo: empty ip")
+	}
+
+	url := "https://ipinfo.io/" + ip + "/json"
+	if p.token != "" {
+		url += "?token=" + p.token
+	}
+
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	var raw struct {
+		IP      string `json:"ip"`
+		Org     string `json:"org"` // "AS15169 Google LLC"
+		Country string `json:"country"`
+		Region  string `json:"region"`
+		City    string `json:"city"`
+		Loc     string `json:"loc"` // "lat,lon"
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return GeoInfo{}, err
+	}
+
+	asn, isp := splitOrg(raw.Org)
+	lat, lon := splitLoc(raw.Loc)
+
+	return GeoInfo{
+		IP:      ip,
+		ISP:     isp,
+		ASN:     asn,
+		Country: raw.Country,
+		Region:  raw.Region,
+		City:    raw.City,
+		Lat:     lat,
+		Lon:     lon,
+	}, nil
+}
+
+// splitOrg splits ipinfo.io's "AS15169 Google LLC" org field into ASN and
+// ISP name.
+func splitOrg(org string) (asn, isp string) {
+	for i, r := range org {
+		if r == ' ' {
+			return org[:i], org[i+1:]
+		}
+	}
+	return "", org
+}
+
+func splitLoc(loc string) (lat, lon float64) {
+	for i, r := range loc {
+		if r == ',' {
+			lat, _ = strconv.ParseFloat(loc[:i], 64)
+			lon, _ = strconv.ParseFloat(loc[i+1:], 64)
+			return
+		}
+	}
+	return 0, 0
+}
+
+// ipsbProvider queries ip.sb, another free keyless backend, useful as a
+// fallback when ip-api.com is rate-limited or blocked.
+type ipsbProvider struct {
+	client *http.Client
+}
+
+func newIPSBProvider() *ipsbProvider {
+	return &ipsbProvider{client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (p *ipsbProvider) Lookup(ip string) (GeoInfo, error) {
+	if ip == "" {
+		return GeoInfo{}, fmt.Errorf("ipgeo: empty ip")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://api.ip.sb/geoip/"+ip, nil)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+	req.Header.Set("User-Agent", "tsping")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	var raw struct {
+		ISP         string  `json:"isp"`
+		ASNumber    int     `json:"asn"`
+		CountryName string  `json:"country"`
+		Region      string  `json:"region"`
+		City        string  `json:"city"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return GeoInfo{}, err
+	}
+
+	asn := ""
+	if raw.ASNumber != 0 {
+		asn = fmt.Sprintf("AS%d", raw.ASNumber)
+	}
+
+	return GeoInfo{
+		IP:      ip,
+		ISP:     raw.ISP,
+		ASN:     asn,
+		Country: raw.CountryName,
+		Region:  raw.Region,
+		City:    raw.City,
+		Lat:     raw.Latitude,
+		Lon:     raw.Longitude,
+	}, nil
+}
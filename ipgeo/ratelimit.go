@@ -0,0 +1,64 @@
+package ipgeo
+
+import (
+	"sync"
+	"time"
+)
+
+// ipAPIRateLimit matches ip-api.com's free-tier limit of 45 requests per
+// minute. We apply the same pacing to every networked backend rather than
+// special casing ip-api.com, since it's a conservative default for any
+// free geo API.
+const ipAPIRateLimit = 45
+
+// rateLimitedProvider paces lookups that miss the cache on one shared
+// ticker, so at most one upstream request starts per tick (ipAPIRateLimit
+// per minute); requests that take longer than a tick may overlap. It also
+// coalesces concurrent lookups for the same IP
+// (several peers behind the same NAT all resolve to one external IP) so
+// they share a single upstream request instead of each sleeping and
+// retrying independently.
+type rateLimitedProvider struct {
+	next   Provider
+	ticker *time.Ticker
+
+	mu       sync.Mutex
+	inflight map[string]*inflightLookup
+}
+
+type inflightLookup struct {
+	done chan struct{}
+	info GeoInfo
+	err  error
+}
+
+func newRateLimitedProvider(next Provider) *rateLimitedProvider {
+	return &rateLimitedProvider{
+		next:     next,
+		ticker:   time.NewTicker(time.Minute / ipAPIRateLimit),
+		inflight: make(map[string]*inflightLookup),
+	}
+}
+
+func (p *rateLimitedProvider) Lookup(ip string) (GeoInfo, error) {
+	p.mu.Lock()
+	if call, ok := p.inflight[ip]; ok {
+		p.mu.Unlock()
+		<-call.done
+		return call.info, call.err
+	}
+
+	call := &inflightLookup{done: make(chan struct{})}
+	p.inflight[ip] = call
+	p.mu.Unlock()
+
+	<-p.ticker.C
+	call.info, call.err = p.next.Lookup(ip)
+	close(call.done)
+
+	p.mu.Lock()
+	delete(p.inflight, ip)
+	p.mu.Unlock()
+
+	return call.info, call.err
+}
@@ -0,0 +1,64 @@
+package ipgeo
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingProvider counts how many times Lookup actually reaches the
+// backend, so tests can assert on dedup/coalescing behavior.
+type countingProvider struct {
+	calls int32
+}
+
+func (p *countingProvider) Lookup(ip string) (GeoInfo, error) {
+	atomic.AddInt32(&p.calls, 1)
+	return GeoInfo{IP: ip, ISP: "test-isp"}, nil
+}
+
+func TestRateLimitedProviderCoalescesConcurrentLookups(t *testing.T) {
+	backend := &countingProvider{}
+	p := newRateLimitedProvider(backend)
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	results := make([]GeoInfo, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			info, err := p.Lookup("1.2.3.4")
+			if err != nil {
+				t.Errorf("Lookup: %v", err)
+			}
+			results[i] = info
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&backend.calls); got != 1 {
+		t.Errorf("backend.calls = %d, want 1 (concurrent lookups for the same IP should coalesce)", got)
+	}
+	for i, info := range results {
+		if info.IP != "1.2.3.4" {
+			t.Errorf("results[%d] = %+v, want IP 1.2.3.4", i, info)
+		}
+	}
+}
+
+func TestRateLimitedProviderDistinctIPs(t *testing.T) {
+	backend := &countingProvider{}
+	p := newRateLimitedProvider(backend)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Lookup(fmt.Sprintf("10.0.0.%d", i)); err != nil {
+			t.Fatalf("Lookup: %v", err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&backend.calls); got != 2 {
+		t.Errorf("backend.calls = %d, want 2 for 2 distinct IPs", got)
+	}
+}
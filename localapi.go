@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/konamata/tsping/ipgeo"
+)
+
+// localAPIAddr is the fake host used in LocalAPI request URLs, matching
+// what tailscaled itself expects over the Unix socket/named pipe.
+const localAPIAddr = "local-tailscaled.sock"
+
+// pingCount is how many probes pingIP sends per peer.
+const pingCount = 5
+
+// newLocalAPIClient returns an http.Client whose transport dials straight
+// into the local tailscaled daemon instead of a real TCP connection, so
+// we never touch the network or depend on a `tailscale` binary on PATH.
+// The actual dial (Unix socket on POSIX, named pipe on Windows) is
+// supplied by dialLocalAPI, which is built per-OS; see
+// localapi_unix.go/localapi_windows.go.
+func newLocalAPIClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: dialLocalAPI,
+		},
+		Timeout: 10 * time.Second,
+	}
+}
+
+type ipnStatus struct {
+	Peer map[string]*struct {
+		HostName     string   `json:"HostName"`
+		DNSName      string   `json:"DNSName"`
+		OS           string   `json:"OS"`
+		UserID       int      `json:"UserID"`
+		TailscaleIPs []string `json:"TailscaleIPs"`
+		Online       bool     `json:"Online"`
+	} `json:"Peer"`
+	User map[string]*struct {
+		LoginName string `json:"LoginName"`
+	} `json:"User"`
+}
+
+// pingResponse mirrors tailscaled's ipn.PingResult.
+type pingResponse struct {
+	Err            string  `json:"Err"`
+	LatencySeconds float64 `json:"LatencySeconds"`
+	Endpoint       string  `json:"Endpoint"` // "ip:port" of the path the ping took
+	DERPRegionID   int     `json:"DERPRegionID"`
+	PeerAPIURL     string  `json:"PeerAPIURL"`
+}
+
+// localAPIGet issues an authenticated-free GET against the local tailscaled
+// daemon (LocalAPI is trusted by virtue of the caller being able to reach
+// the socket/pipe at all) and returns the raw body.
+func localAPIGet(ctx context.Context, client *http.Client, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+localAPIAddr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tailscaled not reachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("localapi %s: %s: %s", path, resp.Status, string(body))
+	}
+	return body, nil
+}
+
+// getTailscaleStatus talks to tailscaled over LocalAPI instead of shelling
+// out to the `tailscale` binary, so it works regardless of PATH, install
+// location, or OS.
+func getTailscaleStatus() ([]PingResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := newLocalAPIClient()
+	body, err := localAPIGet(ctx, client, "/localapi/v0/status")
+	if err != nil {
+		return nil, fmt.Errorf("tailscale status error: %v", err)
+	}
+
+	var status ipnStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("decoding tailscaled status: %v", err)
+	}
+
+	var results []PingResult
+	for _, peer := range status.Peer {
+		if peer == nil || !peer.Online || len(peer.TailscaleIPs) == 0 {
+			continue
+		}
+
+		user := ""
+		if u, ok := status.User[fmt.Sprint(peer.UserID)]; ok && u != nil {
+			user = u.LoginName
+		}
+
+		results = append(results, PingResult{
+			ip:       peer.TailscaleIPs[0],
+			hostname: peer.HostName,
+			user:     user,
+			os:       peer.OS,
+		})
+	}
+	return results, nil
+}
+
+// checkTailscale makes sure tailscaled is actually reachable over
+// LocalAPI before we bother walking the peer list.
+func checkTailscale() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := newLocalAPIClient()
+	if _, err := localAPIGet(ctx, client, "/localapi/v0/status"); err != nil {
+		return fmt.Errorf("tailscaled is not running or not reachable: %v", err)
+	}
+	return nil
+}
+
+// pingIP asks tailscaled to ping a peer over LocalAPI and records the
+// resulting latency/endpoint directly from the decoded PingResult JSON,
+// instead of regexing `tailscale ping` stdout. geo may be nil, in which
+// case the peer's external IP is recorded but not resolved. ctx bounds
+// the whole peer (all pingCount probes); once it's done, pingIP stops
+// early instead of hanging on a wedged daemon.
+func pingIP(ctx context.Context, result *PingResult, geo ipgeo.Provider, wg *sync.WaitGroup, completed *int32) {
+	defer wg.Done()
+	defer atomic.AddInt32(completed, 1)
+
+	client := newLocalAPIClient()
+
+	for i := 0; i < pingCount; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		body, err := localAPIGet(probeCtx, client, "/localapi/v0/ping?ip="+result.ip+"&type=disco")
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		var resp pingResponse
+		if err := json.Unmarshal(body, &resp); err != nil || resp.Err != "" {
+			continue
+		}
+
+		if host, port, err := net.SplitHostPort(resp.Endpoint); err == nil {
+			result.externalIP = host
+			result.port = port
+			if geo != nil {
+				if info, err := geo.Lookup(result.externalIP); err == nil {
+					result.isp = info.ISP
+					result.asn = info.ASN
+					result.country = info.Country
+					result.region = info.Region
+					result.city = info.City
+					result.lat = info.Lat
+					result.lon = info.Lon
+				}
+			}
+		}
+		result.pings = append(result.pings, strconv.Itoa(int(resp.LatencySeconds*1000)))
+	}
+}
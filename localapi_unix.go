@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"net"
+)
+
+// localAPISocket is the Unix socket tailscaled listens on for LocalAPI
+// requests.
+const localAPISocket = "/var/run/tailscale/tailscaled.sock"
+
+// dialLocalAPI connects to tailscaled's LocalAPI over its Unix socket.
+// network and addr are ignored; they only exist to match
+// http.Transport.DialContext's signature.
+func dialLocalAPI(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", localAPISocket)
+}
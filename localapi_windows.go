@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// windowsLocalAPIPipe is the named pipe tailscaled listens on for
+// LocalAPI requests on Windows.
+const windowsLocalAPIPipe = `\\.\pipe\ProtectedPrefix\Administrators\Tailscale\tailscaled`
+
+// dialLocalAPI connects to tailscaled's LocalAPI over its named pipe.
+// The stdlib net.Dialer has no concept of Windows named pipes, so this
+// goes through go-winio instead. network and addr are ignored; they
+// only exist to match http.Transport.DialContext's signature.
+func dialLocalAPI(ctx context.Context, network, addr string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, windowsLocalAPIPipe)
+}
@@ -1,25 +1,18 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"net"
-	"net/http"
 	"os"
-	"os/exec"
-	"regexp"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
+	"github.com/konamata/tsping/ipgeo"
 	"github.com/olekukonko/tablewriter"
-	"github.com/schollz/progressbar/v3"
 )
 
 // PingResult
@@ -33,47 +26,12 @@ type PingResult struct {
 	pings      []string
 	group      string
 	isp        string
-}
-
-// IPInfo represents the JSON structure returned by ip-api.com
-type IPInfo struct {
-	Status    string  `json:"status"`
-	ISP       string  `json:"isp"`
-	Country   string  `json:"country"`
-	Region    string  `json:"regionName"`
-	City      string  `json:"city"`
-	Latitude  float64 `json:"lat"`
-	Longitude float64 `json:"lon"`
-	Query     string  `json:"query"`
-}
-
-// getIPInfo fonksiyonu ekleyelim
-func getIPInfo(ip string) (string, error) {
-	if ip == "" {
-		return "", nil
-	}
-
-	// Rate limiting için kısa bir bekleme
-	time.Sleep(100 * time.Millisecond)
-
-	url := fmt.Sprintf("http://ip-api.com/json/%s", ip)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var info IPInfo
-	if err := json.Unmarshal(body, &info); err != nil {
-		return "", err
-	}
-
-	return info.ISP, nil
+	asn        string
+	country    string
+	region     string
+	city       string
+	lat        float64
+	lon        float64
 }
 
 // Helper function to convert number to letter group with count
@@ -84,120 +42,6 @@ func numberToLetterWithCount(n int, count int) string {
 	return fmt.Sprintf("%s (%d)", string(rune('A'+(n-1))), count)
 }
 
-func getTailscaleStatus() ([]PingResult, error) {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("C:\\Program Files\\Tailscale\\tailscale.exe", "status")
-	case "linux":
-		cmd = exec.Command("tailscale", "status")
-	case "darwin":
-		cmd = exec.Command("/Applications/Tailscale.app/Contents/MacOS/tailscale", "status")
-	default:
-		return nil, fmt.Errorf("unsupported OS")
-	}
-
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("tailscale status error: %v", err)
-	}
-
-	var results []PingResult
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "Self") {
-			continue
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) < 4 {
-			continue
-		}
-
-		status := strings.Join(fields[4:], " ")
-		if strings.Contains(status, "offline") {
-			continue
-		}
-
-		result := PingResult{
-			ip:       fields[0],
-			hostname: fields[1],
-			user:     fields[2],
-			os:       fields[3],
-		}
-
-		results = append(results, result)
-	}
-	return results, nil
-}
-
-func checkTailscale() error {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("C:\\Program Files\\Tailscale\\tailscale.exe", "version")
-	case "linux":
-		cmd = exec.Command("tailscale", "version")
-	case "darwin":
-		cmd = exec.Command("/Applications/Tailscale.app/Contents/MacOS/tailscale", "version")
-	default:
-		return fmt.Errorf("unsupported OS")
-	}
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("tailscale is not installed or not running: %v", err)
-	}
-	return nil
-}
-
-func pingIP(result *PingResult, wg *sync.WaitGroup, completed *int32) {
-	defer wg.Done()
-
-	// Ping işlemi
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("C:\\Program Files\\Tailscale\\tailscale.exe", "ping", "--until-direct=false", "-c", "5", result.ip)
-	case "linux":
-		cmd = exec.Command("tailscale", "ping", "--until-direct=false", "-c", "5", result.ip)
-	case "darwin":
-		cmd = exec.Command("/Applications/Tailscale.app/Contents/MacOS/tailscale", "ping", "--until-direct=false", "-c", "5", result.ip)
-	default:
-		atomic.AddInt32(completed, 1)
-		return
-	}
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		atomic.AddInt32(completed, 1)
-		return
-	}
-
-	publicIPPattern := regexp.MustCompile(`via (\d+\.\d+\.\d+\.\d+):(\d+)`)
-	pingPattern := regexp.MustCompile(`in (\d+)ms`)
-
-	lines := strings.Split(string(output), "\n")
-
-	for _, line := range lines {
-		if match := publicIPPattern.FindStringSubmatch(line); match != nil {
-			result.externalIP = match[1]
-			result.port = match[2]
-
-			// ISP bilgisini al
-			if isp, err := getIPInfo(result.externalIP); err == nil {
-				result.isp = isp
-			}
-		}
-		if match := pingPattern.FindStringSubmatch(line); match != nil {
-			result.pings = append(result.pings, match[1])
-		}
-	}
-
-	atomic.AddInt32(completed, 1)
-}
-
 func isPublicIP(ip string) bool {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
@@ -238,91 +82,80 @@ func calculateAverage(pings []string) float64 {
 }
 
 func main() {
-	err := checkTailscale()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+	ipgeoBackend := flag.String("ipgeo", "ipapi", "IP geolocation provider: ipapi, ipinfo, ipsb, ip2region, ipinfoLocal")
+	ipgeoToken := flag.String("token", "", "API token for keyed ipgeo providers (e.g. ipinfo)")
+	ipgeoDB := flag.String("ipgeo-db", "", "path to an offline .xdb database for the ip2region/ipinfoLocal backend")
+	traceMode := flag.Bool("trace", false, "after pinging, traceroute each peer's external endpoint and print the hop-by-hop path")
+	outputMode := flag.String("output", "table", "output format: table, json, csv, prom")
+	serveAddr := flag.String("serve", "", "run as a daemon, serving an HTTP status/metrics server on this address (e.g. :8080) instead of a one-shot run")
+	serveInterval := flag.Duration("interval", 30*time.Second, "how often --serve re-pings peers")
+	concurrency := flag.Int("concurrency", runtime.NumCPU()*4, "max number of peers pinged at once")
+	peerTimeout := flag.Duration("timeout", 10*time.Second, "max time spent pinging a single peer")
+	deadline := flag.Duration("deadline", 0, "max time for the whole run; 0 means no deadline")
+	flag.Parse()
+
+	if !isValidOutputMode(*outputMode) {
+		fmt.Printf("Error: unknown --output %q (want table, json, csv, or prom)\n", *outputMode)
 		return
 	}
+	output := strings.ToLower(*outputMode)
 
-	resultsList, err := getTailscaleStatus()
+	geo, err := ipgeo.New(*ipgeoBackend, ipgeo.Options{Token: *ipgeoToken, DBPath: *ipgeoDB})
 	if err != nil {
-		fmt.Printf("Error getting Tailscale status: %v\n", err)
+		fmt.Printf("Error setting up ipgeo provider: %v\n", err)
 		return
 	}
 
-	if len(resultsList) == 0 {
-		fmt.Println("No active Tailscale IPs found")
+	opts := collectOptions{Concurrency: *concurrency, PeerTimeout: *peerTimeout}
+
+	if *serveAddr != "" {
+		if err := serve(*serveAddr, *serveInterval, geo, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
 		return
 	}
 
-	var wg sync.WaitGroup
-	var completed int32
-
-	bar := progressbar.NewOptions(len(resultsList),
-		progressbar.OptionEnableColorCodes(true),
-		progressbar.OptionSetWidth(15),
-		progressbar.OptionSetDescription("[cyan]Getting ISP info...[reset]"),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "[green]=[reset]",
-			SaucerHead:    "[green]>[reset]",
-			SaucerPadding: " ",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-		progressbar.OptionSetWriter(os.Stderr),
-		progressbar.OptionOnCompletion(func() {
-			fmt.Fprintf(os.Stderr, "\n")
-		}),
-	)
+	ctx := context.Background()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
 
-	for i := range resultsList {
-		wg.Add(1)
-		go func(result *PingResult) {
-			pingIP(result, &wg, &completed)
-			bar.Add(1)
-		}(&resultsList[i])
+	resultsList, err := collect(ctx, geo, opts, true)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
-	wg.Wait()
+	if len(resultsList) == 0 {
+		fmt.Println("No active Tailscale IPs found")
+		return
+	}
 
-	// Use maps to track group numbers and counts
-	groupMap := make(map[string]int)
-	groupCounter := 1
-	groupCounts := make(map[string]int)
+	if output != "table" {
+		peers := toPeerOutputs(resultsList, pingCount)
 
-	// First pass: Count devices per external IP
-	for _, result := range resultsList {
-		if result.externalIP != "" && isPublicIP(result.externalIP) {
-			groupCounts[result.externalIP]++
+		var err error
+		switch output {
+		case "json":
+			err = writeJSON(os.Stdout, peers)
+		case "csv":
+			err = writeCSV(os.Stdout, peers)
+		case "prom":
+			err = writeProm(os.Stdout, peers)
 		}
-	}
-
-	// Second pass: Assign groups with counts
-	for i := range resultsList {
-		externalIP := resultsList[i].externalIP
-		if externalIP == "" || !isPublicIP(externalIP) {
-			continue
+		if err != nil {
+			fmt.Printf("Error writing %s output: %v\n", output, err)
 		}
-		if _, exists := groupMap[externalIP]; !exists {
-			groupMap[externalIP] = groupCounter
-			groupCounter++
+		if *traceMode {
+			runTraces(resultsList, geo, output)
 		}
-		count := groupCounts[externalIP]
-		resultsList[i].group = numberToLetterWithCount(groupMap[externalIP], count)
+		return
 	}
 
-	// Sort the resultsList
-	sort.Slice(resultsList, func(i, j int) bool {
-		if resultsList[i].group != resultsList[j].group {
-			return resultsList[i].group < resultsList[j].group
-		}
-		iAvg := calculateAverage(resultsList[i].pings)
-		jAvg := calculateAverage(resultsList[j].pings)
-		return iAvg < jAvg
-	})
-
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"#", "User", "Hostname", "OS", "Tailscale IP", "Group", "External IP", "Port", "Ping", "ISP"})
+	table.SetHeader([]string{"#", "User", "Hostname", "OS", "Tailscale IP", "Group", "External IP", "Port", "Ping", "ISP", "ASN", "Country", "Region", "City"})
 	table.SetAutoFormatHeaders(false)
 
 	table.SetColumnAlignment([]int{
@@ -336,6 +169,10 @@ func main() {
 		tablewriter.ALIGN_CENTER,
 		tablewriter.ALIGN_RIGHT,
 		tablewriter.ALIGN_LEFT,
+		tablewriter.ALIGN_LEFT,
+		tablewriter.ALIGN_LEFT,
+		tablewriter.ALIGN_LEFT,
+		tablewriter.ALIGN_LEFT,
 	})
 
 	table.SetHeaderColor(
@@ -349,6 +186,10 @@ func main() {
 		tablewriter.Colors{tablewriter.FgHiGreenColor},
 		tablewriter.Colors{tablewriter.FgHiGreenColor},
 		tablewriter.Colors{tablewriter.FgHiGreenColor},
+		tablewriter.Colors{tablewriter.FgHiGreenColor},
+		tablewriter.Colors{tablewriter.FgHiGreenColor},
+		tablewriter.Colors{tablewriter.FgHiGreenColor},
+		tablewriter.Colors{tablewriter.FgHiGreenColor},
 	)
 
 	table.SetColumnColor(
@@ -362,6 +203,10 @@ func main() {
 		tablewriter.Colors{tablewriter.FgCyanColor},
 		tablewriter.Colors{tablewriter.FgWhiteColor},
 		tablewriter.Colors{tablewriter.FgHiGreenColor},
+		tablewriter.Colors{tablewriter.FgHiGreenColor},
+		tablewriter.Colors{tablewriter.FgWhiteColor},
+		tablewriter.Colors{tablewriter.FgWhiteColor},
+		tablewriter.Colors{tablewriter.FgWhiteColor},
 	)
 
 	table.SetBorder(true)
@@ -388,8 +233,16 @@ func main() {
 			result.port,
 			avgPingStr,
 			result.isp,
+			result.asn,
+			result.country,
+			result.region,
+			result.city,
 		})
 		i++
 	}
 	table.Render()
+
+	if *traceMode {
+		runTraces(resultsList, geo, output)
+	}
 }
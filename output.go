@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// PeerOutput is the exported, structured shape of a PingResult used by
+// the json/csv/prom output modes. Field names match what scripts and
+// the Prometheus textfile exporter expect.
+type PeerOutput struct {
+	Hostname    string  `json:"hostname"`
+	User        string  `json:"user"`
+	OS          string  `json:"os"`
+	TailscaleIP string  `json:"tailscale_ip"`
+	Group       string  `json:"group"`
+	ExternalIP  string  `json:"external_ip"`
+	Port        string  `json:"port"`
+	ISP         string  `json:"isp"`
+	ASN         string  `json:"asn"`
+	Country     string  `json:"country"`
+	Region      string  `json:"region"`
+	City        string  `json:"city"`
+	RTTMinMs    float64 `json:"rtt_min_ms"`
+	RTTAvgMs    float64 `json:"rtt_avg_ms"`
+	RTTMaxMs    float64 `json:"rtt_max_ms"`
+	RTTMdevMs   float64 `json:"rtt_mdev_ms"`
+	LossRatio   float64 `json:"loss_ratio"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+}
+
+// toPeerOutputs converts the internal PingResult slice into the exported
+// shape shared by every structured output mode, computing RTT stats and
+// loss ratio from the raw per-probe samples.
+func toPeerOutputs(resultsList []PingResult, pingCount int) []PeerOutput {
+	out := make([]PeerOutput, 0, len(resultsList))
+	for _, r := range resultsList {
+		min, avg, max, mdev := rttStats(r.pings)
+		loss := 0.0
+		if pingCount > 0 {
+			loss = 1 - float64(len(r.pings))/float64(pingCount)
+		}
+
+		out = append(out, PeerOutput{
+			Hostname:    r.hostname,
+			User:        r.user,
+			OS:          r.os,
+			TailscaleIP: r.ip,
+			Group:       r.group,
+			ExternalIP:  r.externalIP,
+			Port:        r.port,
+			ISP:         r.isp,
+			ASN:         r.asn,
+			Country:     r.country,
+			Region:      r.region,
+			City:        r.city,
+			RTTMinMs:    min,
+			RTTAvgMs:    avg,
+			RTTMaxMs:    max,
+			RTTMdevMs:   mdev,
+			LossRatio:   loss,
+			Lat:         r.lat,
+			Lon:         r.lon,
+		})
+	}
+	return out
+}
+
+// rttStats computes min/avg/max/mdev (standard deviation) over a peer's raw
+// ping samples, mirroring the stats `ping`/`mtr` report.
+func rttStats(pings []string) (min, avg, max, mdev float64) {
+	var vals []float64
+	for _, p := range pings {
+		var v float64
+		if _, err := fmt.Sscanf(p, "%f", &v); err == nil {
+			vals = append(vals, v)
+		}
+	}
+	if len(vals) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	min, max = vals[0], vals[0]
+	sum := 0.0
+	for _, v := range vals {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+		sum += v
+	}
+	avg = sum / float64(len(vals))
+
+	devSum := 0.0
+	for _, v := range vals {
+		d := v - avg
+		devSum += d * d
+	}
+	mdev = math.Sqrt(devSum / float64(len(vals)))
+
+	return min, avg, max, mdev
+}
+
+// writeJSON emits the peer list as a JSON array.
+func writeJSON(w io.Writer, peers []PeerOutput) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(peers)
+}
+
+// writeCSV emits the peer list as CSV with a header row.
+func writeCSV(w io.Writer, peers []PeerOutput) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	header := []string{"hostname", "user", "os", "tailscale_ip", "group", "external_ip", "port",
+		"isp", "asn", "country", "region", "city", "lat", "lon", "rtt_min_ms", "rtt_avg_ms", "rtt_max_ms", "rtt_mdev_ms", "loss_ratio"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, p := range peers {
+		row := []string{
+			p.Hostname, p.User, p.OS, p.TailscaleIP, p.Group, p.ExternalIP, p.Port,
+			p.ISP, p.ASN, p.Country, p.Region, p.City,
+			fmt.Sprintf("%.6f", p.Lat), fmt.Sprintf("%.6f", p.Lon),
+			fmt.Sprintf("%.3f", p.RTTMinMs), fmt.Sprintf("%.3f", p.RTTAvgMs),
+			fmt.Sprintf("%.3f", p.RTTMaxMs), fmt.Sprintf("%.3f", p.RTTMdevMs),
+			fmt.Sprintf("%.4f", p.LossRatio),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProm emits a node_exporter textfile-collector compatible set of
+// metrics, so `tsping --output=prom > /var/lib/node_exporter/tsping.prom`
+// can be cron'd and scraped.
+func writeProm(w io.Writer, peers []PeerOutput) error {
+	fmt.Fprintln(w, "# HELP tsping_peer_rtt_ms Average ping RTT to a tailnet peer, in milliseconds.")
+	fmt.Fprintln(w, "# TYPE tsping_peer_rtt_ms gauge")
+	for _, p := range peers {
+		fmt.Fprintf(w, "tsping_peer_rtt_ms{hostname=%q,user=%q,external_ip=%q,isp=%q,group=%q} %.3f\n",
+			p.Hostname, p.User, p.ExternalIP, p.ISP, p.Group, p.RTTAvgMs)
+	}
+
+	fmt.Fprintln(w, "# HELP tsping_peer_loss_ratio Fraction of pings lost to a tailnet peer.")
+	fmt.Fprintln(w, "# TYPE tsping_peer_loss_ratio gauge")
+	for _, p := range peers {
+		fmt.Fprintf(w, "tsping_peer_loss_ratio{hostname=%q,user=%q,external_ip=%q,isp=%q,group=%q} %.4f\n",
+			p.Hostname, p.User, p.ExternalIP, p.ISP, p.Group, p.LossRatio)
+	}
+	return nil
+}
+
+// validOutputModes lists the --output values tsping accepts.
+var validOutputModes = map[string]bool{"table": true, "json": true, "csv": true, "prom": true}
+
+func isValidOutputMode(mode string) bool {
+	return validOutputModes[strings.ToLower(mode)]
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestRTTStats(t *testing.T) {
+	min, avg, max, mdev := rttStats([]string{"10", "20", "30"})
+	if min != 10 || max != 30 || avg != 20 {
+		t.Errorf("rttStats = min=%v avg=%v max=%v, want min=10 avg=20 max=30", min, avg, max)
+	}
+	if mdev <= 0 {
+		t.Errorf("rttStats mdev = %v, want > 0 for non-uniform samples", mdev)
+	}
+}
+
+func TestRTTStatsIgnoresUnparsable(t *testing.T) {
+	min, avg, max, _ := rttStats([]string{"10", "*", "20"})
+	if min != 10 || max != 20 || avg != 15 {
+		t.Errorf("rttStats = min=%v avg=%v max=%v, want min=10 avg=15 max=20", min, avg, max)
+	}
+}
+
+func TestRTTStatsEmpty(t *testing.T) {
+	min, avg, max, mdev := rttStats(nil)
+	if min != 0 || avg != 0 || max != 0 || mdev != 0 {
+		t.Errorf("rttStats(nil) = %v,%v,%v,%v, want all zero", min, avg, max, mdev)
+	}
+}
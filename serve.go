@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konamata/tsping/ipgeo"
+)
+
+// historyWindow caps how many samples we keep per peer so a long-running
+// daemon doesn't grow memory unbounded.
+const historyWindow = 100
+
+// sample is one point in a peer's rolling history.
+type sample struct {
+	Time     time.Time `json:"time"`
+	RTTAvgMs float64   `json:"rtt_avg_ms"`
+	Loss     float64   `json:"loss_ratio"`
+}
+
+// peerState tracks the latest snapshot and rolling sample history for one
+// peer, identified by its Tailscale IP.
+type peerState struct {
+	mu      sync.Mutex
+	latest  PeerOutput
+	history []sample
+}
+
+// server holds all peer state for the --serve daemon and serves it over
+// HTTP. It re-runs collect() on a timer, so the HTTP handlers below only
+// ever read the most recent snapshot.
+type server struct {
+	geo      ipgeo.Provider
+	interval time.Duration
+	opts     collectOptions
+
+	mu    sync.RWMutex
+	peers map[string]*peerState
+	order []string // insertion order, so the HTML/JSON views are stable
+}
+
+// serve runs tsping as a long-lived daemon: it keeps sampling peers on
+// interval and exposes the results over HTTP, so users don't have to
+// wrap tsping in cron + node_exporter themselves.
+func serve(addr string, interval time.Duration, geo ipgeo.Provider, opts collectOptions) error {
+	s := &server{
+		geo:      geo,
+		interval: interval,
+		opts:     opts,
+		peers:    make(map[string]*peerState),
+	}
+
+	go s.sampleLoop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/peers", s.handlePeers)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/api/peers/", s.handlePeerHistory)
+
+	log.Printf("tsping: serving on %s (sampling every %s)", addr, interval)
+	return http.ListenAndServe(addr, mux)
+}
+
+// sampleLoop re-pings every peer on s.interval and folds the results into
+// each peer's rolling history. It runs once immediately so the server
+// doesn't start out empty. Each round only sleeps what's left of the
+// interval after collect() returns, so samples land on s.interval
+// instead of s.interval plus however long the round took.
+func (s *server) sampleLoop() {
+	for {
+		start := time.Now()
+
+		// Each sampling round gets its own deadline of one interval, so a
+		// wedged daemon can't make rounds pile up indefinitely.
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		resultsList, err := collect(ctx, s.geo, s.opts, false)
+		cancel()
+		if err != nil {
+			log.Printf("tsping: sample failed: %v", err)
+		} else {
+			s.record(resultsList)
+		}
+
+		if remaining := s.interval - time.Since(start); remaining > 0 {
+			time.Sleep(remaining)
+		}
+	}
+}
+
+func (s *server) record(resultsList []PingResult) {
+	peers := toPeerOutputs(resultsList, pingCount)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range peers {
+		ps, ok := s.peers[p.TailscaleIP]
+		if !ok {
+			ps = &peerState{}
+			s.peers[p.TailscaleIP] = ps
+			s.order = append(s.order, p.TailscaleIP)
+		}
+
+		ps.mu.Lock()
+		ps.latest = p
+		ps.history = append(ps.history, sample{Time: time.Now(), RTTAvgMs: p.RTTAvgMs, Loss: p.LossRatio})
+		if len(ps.history) > historyWindow {
+			ps.history = ps.history[len(ps.history)-historyWindow:]
+		}
+		ps.mu.Unlock()
+	}
+}
+
+// snapshot returns the current PeerOutput for every known peer, in
+// stable order.
+func (s *server) snapshot() []PeerOutput {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]PeerOutput, 0, len(s.order))
+	for _, ip := range s.order {
+		ps := s.peers[ip]
+		ps.mu.Lock()
+		out = append(out, ps.latest)
+		ps.mu.Unlock()
+	}
+	return out
+}
+
+func (s *server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, s.snapshot()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := writeProm(w, s.snapshot()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handlePeerHistory serves GET /api/peers/{ip}/history.
+func (s *server) handlePeerHistory(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/peers/")
+	ip := strings.TrimSuffix(path, "/history")
+	if ip == path || ip == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.RLock()
+	ps, ok := s.peers[ip]
+	s.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	ps.mu.Lock()
+	history := make([]sample, len(ps.history))
+	copy(history, ps.history)
+	ps.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var indexTemplateFuncs = template.FuncMap{
+	// LossRatio is a 0-1 fraction; the template renders it as a percentage.
+	"pct": func(ratio float64) float64 { return ratio * 100 },
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(indexTemplateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head><title>tsping</title></head>
+<body>
+<h1>tsping</h1>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>User</th><th>Hostname</th><th>OS</th><th>Tailscale IP</th><th>Group</th><th>External IP</th><th>ISP</th><th>ASN</th><th>RTT avg (ms)</th><th>Loss</th></tr>
+{{range .}}<tr><td>{{.User}}</td><td>{{.Hostname}}</td><td>{{.OS}}</td><td>{{.TailscaleIP}}</td><td>{{.Group}}</td><td>{{.ExternalIP}}</td><td>{{.ISP}}</td><td>{{.ASN}}</td><td>{{printf "%.1f" .RTTAvgMs}}</td><td>{{printf "%.0f" (pct .LossRatio)}}%</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, s.snapshot()); err != nil {
+		fmt.Fprintf(w, "template error: %v", err)
+	}
+}
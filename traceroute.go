@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konamata/tsping/ipgeo"
+	"github.com/olekukonko/tablewriter"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// MPLSLabel is a single decoded entry from an ICMP Extension's MPLS Label
+// Stack object (RFC 4950).
+type MPLSLabel struct {
+	Label uint32 `json:"label"`
+	TC    uint8  `json:"tc"`  // traffic class (formerly EXP)
+	S     bool   `json:"s"`   // bottom-of-stack
+	TTL   uint8  `json:"ttl"`
+}
+
+// TraceHop is one hop of a traceroute toward a peer's external endpoint.
+type TraceHop struct {
+	Hop      int           `json:"hop"`
+	Addr     string        `json:"addr"` // empty if the hop timed out
+	RTT      time.Duration `json:"rtt_ns"`
+	ASN      string        `json:"asn"`
+	MPLS     []MPLSLabel   `json:"mpls,omitempty"`
+	TimedOut bool          `json:"timed_out"`
+}
+
+// TraceResult is the hop-by-hop path tsping recorded toward one peer's
+// external IP.
+type TraceResult struct {
+	PeerIP     string     `json:"peer_ip"` // tailscale IP
+	Hostname   string     `json:"hostname"`
+	ExternalIP string     `json:"external_ip"`
+	Hops       []TraceHop `json:"hops"`
+}
+
+const (
+	traceMaxHops      = 30
+	traceTimeout      = time.Second
+	traceProbesPerHop = 1
+)
+
+// icmpExtMPLSClass/CType identify the MPLS Label Stack object inside
+// an ICMP Extension Structure, per RFC 4950 section 3.
+const (
+	icmpExtMPLSClass = 1
+	icmpExtMPLSCType = 1
+)
+
+// traceRoute runs a pure-Go UDP/ICMP traceroute toward dst, decoding any
+// MPLS label stack carried in ICMP Time Exceeded extension objects along
+// the way. It never shells out to the system `traceroute` binary.
+func traceRoute(dst string, geo geoLookupFunc) (*TraceResult, error) {
+	dstIP := net.ParseIP(dst)
+	if dstIP == nil {
+		return nil, fmt.Errorf("traceroute: invalid destination %q", dst)
+	}
+
+	recvConn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("traceroute: listening for ICMP replies: %w (may need CAP_NET_RAW)", err)
+	}
+	defer recvConn.Close()
+
+	sendConn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return nil, fmt.Errorf("traceroute: opening UDP probe socket: %w", err)
+	}
+	defer sendConn.Close()
+
+	pconn := ipv4.NewPacketConn(sendConn)
+
+	result := &TraceResult{ExternalIP: dst}
+
+	destPort := 33434
+	for ttl := 1; ttl <= traceMaxHops; ttl++ {
+		if err := pconn.SetTTL(ttl); err != nil {
+			return nil, fmt.Errorf("traceroute: setting TTL: %w", err)
+		}
+
+		start := time.Now()
+		_, err := sendConn.WriteTo([]byte("tsping"), &net.UDPAddr{IP: dstIP, Port: destPort + ttl})
+		if err != nil {
+			return nil, fmt.Errorf("traceroute: sending probe: %w", err)
+		}
+
+		hop := TraceHop{Hop: ttl}
+
+		if err := recvConn.SetReadDeadline(time.Now().Add(traceTimeout)); err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, 1500)
+		n, peer, err := recvConn.ReadFrom(buf)
+		if err != nil {
+			hop.TimedOut = true
+			result.Hops = append(result.Hops, hop)
+			continue
+		}
+		hop.RTT = time.Since(start)
+
+		msg, err := icmp.ParseMessage(1 /* IANA protocol number for ICMPv4 */, buf[:n])
+		if err == nil {
+			hop.Addr = peer.String()
+			hop.MPLS = parseMPLSFromICMP(msg, buf[:n])
+			if geo != nil {
+				if asn, err := geo(hop.Addr); err == nil {
+					hop.ASN = asn
+				}
+			}
+		}
+
+		result.Hops = append(result.Hops, hop)
+
+		if hop.Addr == dst {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// geoLookupFunc resolves a hop address to an ASN string; wired up to the
+// ipgeo package by the caller so this file doesn't need to know about
+// providers directly.
+type geoLookupFunc func(ip string) (string, error)
+
+// runTraces traceroutes each peer's external endpoint (deduplicating
+// peers that share one, e.g. behind the same NAT), rendering the result
+// the same way as output: a tablewriter table per endpoint for "table",
+// or a single JSON document for json/csv/prom so --trace never produces
+// output inconsistent with the rest of the run.
+func runTraces(resultsList []PingResult, geo ipgeo.Provider, output string) {
+	seen := make(map[string]bool)
+
+	asnLookup := func(ip string) (string, error) {
+		if geo == nil {
+			return "", fmt.Errorf("no ipgeo provider configured")
+		}
+		info, err := geo.Lookup(ip)
+		if err != nil {
+			return "", err
+		}
+		return info.ASN, nil
+	}
+
+	var traces []*TraceResult
+	for _, result := range resultsList {
+		if result.externalIP == "" || seen[result.externalIP] {
+			continue
+		}
+		seen[result.externalIP] = true
+
+		trace, err := traceRoute(result.externalIP, asnLookup)
+		if err != nil {
+			fmt.Printf("traceroute to %s (%s): error: %v\n", result.hostname, result.externalIP, err)
+			continue
+		}
+		trace.PeerIP = result.ip
+		trace.Hostname = result.hostname
+		traces = append(traces, trace)
+	}
+
+	if output != "table" {
+		if err := writeTraceJSON(os.Stdout, traces); err != nil {
+			fmt.Printf("Error writing trace output: %v\n", err)
+		}
+		return
+	}
+
+	for _, trace := range traces {
+		renderTraceTable(trace)
+	}
+}
+
+// renderTraceTable prints one hop-by-hop tablewriter table for trace, to
+// match how every other output in the program (see main.go) renders.
+func renderTraceTable(trace *TraceResult) {
+	fmt.Printf("\ntraceroute to %s (%s, via %s)\n", trace.Hostname, trace.ExternalIP, trace.PeerIP)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Hop", "Addr", "RTT", "ASN", "MPLS"})
+	table.SetAutoFormatHeaders(false)
+
+	for _, hop := range trace.Hops {
+		addr, rtt := "*", ""
+		if !hop.TimedOut {
+			addr = hop.Addr
+			rtt = hop.RTT.Round(time.Millisecond).String()
+		}
+
+		var mpls []string
+		for _, label := range hop.MPLS {
+			mpls = append(mpls, fmt.Sprintf("L=%d,TC=%d,S=%t,TTL=%d", label.Label, label.TC, label.S, label.TTL))
+		}
+
+		table.Append([]string{strconv.Itoa(hop.Hop), addr, rtt, hop.ASN, strings.Join(mpls, "; ")})
+	}
+	table.Render()
+}
+
+// writeTraceJSON emits the trace results as a JSON array, mirroring
+// writeJSON's encoding style in output.go.
+func writeTraceJSON(w io.Writer, traces []*TraceResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(traces)
+}
+
+// icmpHeaderLen is the fixed 8-byte ICMP header common to Time Exceeded
+// messages: type(1) + code(1) + checksum(2) + unused(1) + length(1) +
+// unused(2). raw is everything icmp.ParseMessage was given, i.e. this
+// header followed by the original-datagram copy and (optionally) the
+// RFC 4884 extension structure.
+const icmpHeaderLen = 8
+
+// icmpExtensionPaddedLen is the de-facto convention most routers still
+// follow when they report a zero length field: pad the original-datagram
+// copy to 128 bytes before appending any extension structure. RFC 4884
+// §3 calls this out as the pre-existing behavior the length field was
+// added to disambiguate.
+const icmpExtensionPaddedLen = 128
+
+// parseMPLSFromICMP looks for an RFC 4950 ICMP Extension Structure in msg
+// and decodes any MPLS Label Stack object it contains. raw is the full
+// ICMP message as read off the wire, since the extension structure's
+// offset is computed from the message's own length field rather than
+// anything icmp.Message exposes.
+func parseMPLSFromICMP(msg *icmp.Message, raw []byte) []MPLSLabel {
+	if _, ok := msg.Body.(*icmp.TimeExceeded); !ok {
+		return nil
+	}
+	return parseMPLSExtensions(raw)
+}
+
+// parseMPLSExtensions decodes the ICMP extension structure (RFC 4884)
+// trailing an ICMP Time Exceeded message and pulls out MPLS Label Stack
+// objects (RFC 4950).
+//
+// Layout: [8-byte ICMP header, length field at byte 5] [original
+// datagram copy] [2-byte version+reserved] [2-byte checksum] then one or
+// more object headers: [2-byte length][1-byte class-num][1-byte
+// c-type][length-4 bytes payload].
+func parseMPLSExtensions(raw []byte) []MPLSLabel {
+	if len(raw) < icmpHeaderLen+4 {
+		return nil
+	}
+
+	// Byte 5 is the length field RFC 4884 added: length of the original
+	// datagram copy, in 32-bit words. A router that sets it lets us find
+	// the extension header exactly; one that doesn't is falling back to
+	// the legacy 128-byte padding convention.
+	extOffset := icmpHeaderLen + icmpExtensionPaddedLen
+	if lengthWords := int(raw[5]); lengthWords > 0 {
+		extOffset = icmpHeaderLen + lengthWords*4
+	}
+	if extOffset+4 > len(raw) {
+		return nil
+	}
+
+	if raw[extOffset]>>4 != 2 { // version nibble must be 2 per RFC 4884
+		return nil
+	}
+	return decodeMPLSObjects(raw[extOffset+4:])
+}
+
+func decodeMPLSObjects(data []byte) []MPLSLabel {
+	var labels []MPLSLabel
+	for len(data) >= 4 {
+		objLen := int(binary.BigEndian.Uint16(data[0:2]))
+		class := data[2]
+		ctype := data[3]
+		if objLen < 4 || objLen > len(data) {
+			break
+		}
+
+		if class == icmpExtMPLSClass && ctype == icmpExtMPLSCType {
+			payload := data[4:objLen]
+			for len(payload) >= 4 {
+				entry := binary.BigEndian.Uint32(payload[0:4])
+				labels = append(labels, MPLSLabel{
+					Label: entry >> 12,
+					TC:    uint8((entry >> 9) & 0x7),
+					S:     (entry>>8)&0x1 == 1,
+					TTL:   uint8(entry & 0xFF),
+				})
+				payload = payload[4:]
+			}
+		}
+
+		data = data[objLen:]
+	}
+	return labels
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// mplsObject builds one ICMP Extension Structure object header plus an
+// MPLS Label Stack payload (RFC 4950 §3) carrying labels.
+func mplsObject(labels ...MPLSLabel) []byte {
+	payload := make([]byte, 0, len(labels)*4)
+	for _, l := range labels {
+		var entry uint32
+		entry |= l.Label << 12
+		entry |= uint32(l.TC&0x7) << 9
+		if l.S {
+			entry |= 1 << 8
+		}
+		entry |= uint32(l.TTL)
+		buf := make([]byte, 4)
+		binary.BigEndian.PutUint32(buf, entry)
+		payload = append(payload, buf...)
+	}
+
+	objLen := 4 + len(payload)
+	obj := make([]byte, 2, objLen)
+	binary.BigEndian.PutUint16(obj, uint16(objLen))
+	obj = append(obj, icmpExtMPLSClass, icmpExtMPLSCType)
+	obj = append(obj, payload...)
+	return obj
+}
+
+func TestDecodeMPLSObjects(t *testing.T) {
+	want := []MPLSLabel{
+		{Label: 100, TC: 3, S: true, TTL: 64},
+		{Label: 200, TC: 0, S: false, TTL: 1},
+	}
+
+	got := decodeMPLSObjects(mplsObject(want...))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeMPLSObjects = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeMPLSObjectsIgnoresOtherClasses(t *testing.T) {
+	obj := []byte{0x00, 0x08, 0x09, 0x09, 0xAA, 0xBB, 0xCC, 0xDD} // class/ctype != MPLS
+	if got := decodeMPLSObjects(obj); got != nil {
+		t.Errorf("decodeMPLSObjects = %+v, want nil", got)
+	}
+}
+
+func TestDecodeMPLSObjectsTruncated(t *testing.T) {
+	if got := decodeMPLSObjects([]byte{0x00, 0x04}); got != nil {
+		t.Errorf("decodeMPLSObjects(truncated) = %+v, want nil", got)
+	}
+}
+
+// icmpTimeExceeded builds a raw ICMP Time Exceeded message with a given
+// original-datagram length (in 32-bit words) and an RFC 4884 extension
+// structure wrapping ext.
+func icmpTimeExceeded(lengthWords int, ext []byte) []byte {
+	raw := make([]byte, icmpHeaderLen)
+	raw[0] = 11 // ICMP Time Exceeded
+	raw[5] = byte(lengthWords)
+
+	datagram := make([]byte, lengthWords*4)
+	raw = append(raw, datagram...)
+
+	header := []byte{0x20, 0x00, 0x00, 0x00} // version 2, reserved 0, checksum
+	raw = append(raw, header...)
+	raw = append(raw, ext...)
+	return raw
+}
+
+func TestParseMPLSExtensionsUsesLengthField(t *testing.T) {
+	want := []MPLSLabel{{Label: 42, TC: 1, S: true, TTL: 255}}
+	raw := icmpTimeExceeded(7, mplsObject(want...))
+
+	got := parseMPLSExtensions(raw)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMPLSExtensions = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseMPLSExtensionsFallsBackTo128ByteConvention(t *testing.T) {
+	want := []MPLSLabel{{Label: 7, TC: 2, S: false, TTL: 30}}
+	raw := icmpTimeExceeded(0, mplsObject(want...))
+	// icmpTimeExceeded(0, ...) leaves no padded datagram, so pad raw out to
+	// the legacy 128-byte convention parseMPLSExtensions falls back to
+	// when the length field is absent.
+	padded := make([]byte, icmpHeaderLen+icmpExtensionPaddedLen)
+	copy(padded, raw[:icmpHeaderLen])
+	ext := raw[icmpHeaderLen:]
+	padded = append(padded, ext...)
+
+	got := parseMPLSExtensions(padded)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMPLSExtensions = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseMPLSExtensionsNoExtensionHeader(t *testing.T) {
+	raw := make([]byte, icmpHeaderLen+icmpExtensionPaddedLen+4)
+	if got := parseMPLSExtensions(raw); got != nil {
+		t.Errorf("parseMPLSExtensions = %+v, want nil", got)
+	}
+}
+
+func TestParseMPLSExtensionsTooShort(t *testing.T) {
+	if got := parseMPLSExtensions(make([]byte, 4)); got != nil {
+		t.Errorf("parseMPLSExtensions(short) = %+v, want nil", got)
+	}
+}